@@ -0,0 +1,38 @@
+package httpclient
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+)
+
+// Request sends a method request to url, marshaling v to JSON and setting
+// Content-Type: application/json. v may be nil for a bodyless request
+// (e.g. GET/DELETE). This is the typed-body counterpart to Post, which
+// only knows how to encode map[string]string form params.
+func (this *HttpClient) Request(method string, url string, v interface{}) (*http.Response, error) {
+    if v == nil {
+        return this.Do(method, url, nil, nil)
+    }
+
+    data, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+
+    headers := mergeHeaders(this.Headers, map[string]string{"Content-Type": "application/json"})
+
+    return this.Do(method, url, headers, bytes.NewReader(data))
+}
+
+// PostJSON POSTs v to url, marshaled as a JSON body.
+func (this *HttpClient) PostJSON(url string, v interface{}) (*http.Response, error) {
+    return this.Request("POST", url, v)
+}
+
+// PostRaw POSTs body to url as-is, with the given Content-Type.
+func (this *HttpClient) PostRaw(url string, contentType string, body []byte) (*http.Response, error) {
+    headers := mergeHeaders(this.Headers, map[string]string{"Content-Type": contentType})
+
+    return this.Do("POST", url, headers, bytes.NewReader(body))
+}
@@ -1,6 +1,7 @@
 package httpclient
 
 import (
+    "context"
     "fmt"
 
     "strings"
@@ -50,6 +51,20 @@ const (
     // Other OPT
     OPT_REDIRECT_POLICY = 100000
     OPT_PROXY_FUNC = 100001
+    OPT_PROGRESS_FUNC = 100002
+    OPT_INSECURE = 100003
+    OPT_TLS_CONFIG = 100004
+    OPT_CLIENT_CERT = 100005
+    OPT_ROOT_CAS = 100006
+    OPT_MAX_IDLE_CONNS = 100007
+    OPT_MAX_IDLE_CONNS_PER_HOST = 100008
+    OPT_IDLE_CONN_TIMEOUT_MS = 100009
+    OPT_DISABLE_KEEPALIVES = 100010
+    OPT_DISABLE_COMPRESSION = 100011
+    OPT_MAX_RETRIES = 100012
+    OPT_RETRY_BACKOFF_MS = 100013
+    OPT_RETRY_MAX_BACKOFF_MS = 100014
+    OPT_RETRY_ON_STATUS = 100015
 )
 
 var CONST = map[string]int {
@@ -69,6 +84,20 @@ var CONST = map[string]int {
 
     "OPT_REDIRECT_POLICY": 100000,
     "OPT_PROXY_FUNC": 100001,
+    "OPT_PROGRESS_FUNC": 100002,
+    "OPT_INSECURE": 100003,
+    "OPT_TLS_CONFIG": 100004,
+    "OPT_CLIENT_CERT": 100005,
+    "OPT_ROOT_CAS": 100006,
+    "OPT_MAX_IDLE_CONNS": 100007,
+    "OPT_MAX_IDLE_CONNS_PER_HOST": 100008,
+    "OPT_IDLE_CONN_TIMEOUT_MS": 100009,
+    "OPT_DISABLE_KEEPALIVES": 100010,
+    "OPT_DISABLE_COMPRESSION": 100011,
+    "OPT_MAX_RETRIES": 100012,
+    "OPT_RETRY_BACKOFF_MS": 100013,
+    "OPT_RETRY_MAX_BACKOFF_MS": 100014,
+    "OPT_RETRY_ON_STATUS": 100015,
 }
 
 var defaultOptions = map[int]interface{} {
@@ -89,6 +118,15 @@ var transportOptions = []int {
     OPT_INTERFACE,
     OPT_PROXY,
     OPT_PROXY_FUNC,
+    OPT_INSECURE,
+    OPT_TLS_CONFIG,
+    OPT_CLIENT_CERT,
+    OPT_ROOT_CAS,
+    OPT_MAX_IDLE_CONNS,
+    OPT_MAX_IDLE_CONNS_PER_HOST,
+    OPT_IDLE_CONN_TIMEOUT_MS,
+    OPT_DISABLE_KEEPALIVES,
+    OPT_DISABLE_COMPRESSION,
 }
 
 // following options will affect cookie jar
@@ -161,39 +199,60 @@ func prepareTransport(options map[int]interface{}) (http.RoundTripper, error) {
         connectTimeoutMS = timeoutMS
     }
 
-    transport.Dial = func (network, addr string) (net.Conn, error) {
-        var conn net.Conn
-        var err error
-        if connectTimeoutMS > 0 {
-            conn, err = net.DialTimeout(network, addr, time.Duration(connectTimeoutMS) * time.Millisecond)
-            if err != nil {
-                return nil, err
-            }
-        } else {
-            conn, err = net.Dial(network, addr)
-            if err != nil {
-                return nil, err
-            }
-        }
+    // dialDirect performs the actual TCP connect, bounded by connectTimeoutMS.
+    // The per-attempt timeout (OPT_TIMEOUT/OPT_TIMEOUT_MS) is applied in
+    // DoContext() as http.Client.Timeout instead of as a conn.SetDeadline here: a
+    // deadline set on the raw conn sticks around for as long as the conn is
+    // kept alive in the pool, which would silently kill reused connections.
+    netDialer := &net.Dialer{}
 
-        if timeoutMS > 0 {
-            conn.SetDeadline(time.Now().Add(time.Duration(timeoutMS) * time.Millisecond))
+    dialDirect := func (ctx context.Context, network, addr string) (net.Conn, error) {
+        if connectTimeoutMS > 0 {
+            var cancel context.CancelFunc
+            ctx, cancel = context.WithTimeout(ctx, time.Duration(connectTimeoutMS) * time.Millisecond)
+            defer cancel()
         }
 
-        return conn, nil
+        return netDialer.DialContext(ctx, network, addr)
     }
 
+    transport.DialContext = dialDirect
+
     // proxy
     if proxyFunc_, ok := options[OPT_PROXY_FUNC]; ok {
         if proxyFunc, ok := proxyFunc_.(func (*http.Request) (int, string, error)); ok {
+            // SOCKS proxies tunnel raw TCP, so they have to be wired up via
+            // transport.DialContext rather than transport.Proxy. DialContext
+            // only sees the dial-time network address, which for a
+            // PROXY_HTTP decision is the *proxy's* address, not the original
+            // target - so it can't just call proxyFunc(addr) again to learn
+            // the routing. Instead, transport.Proxy (which does see the real
+            // *http.Request) stashes the resolved decision on the request's
+            // context, and DialContext reads it back; http.Transport dials
+            // using that same request's (derived) context.
+            transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+                decision, _ := ctx.Value(proxyFuncDecisionKey{}).(proxyFuncDecision)
+
+                if decision.proxyType == PROXY_HTTP || decision.proxyAddr == "" {
+                    return dialDirect(ctx, network, addr)
+                }
+
+                return dialSocksProxy(ctx, dialDirect, decision.proxyType, decision.proxyAddr, addr)
+            }
+
             transport.Proxy = func(req *http.Request) (*url.URL, error) {
                 proxyType, u_, err := proxyFunc(req)
                 if err != nil {
                     return nil, err
                 }
 
+                decision := proxyFuncDecision{proxyType: proxyType, proxyAddr: u_}
+                *req = *req.WithContext(context.WithValue(req.Context(), proxyFuncDecisionKey{}, decision))
+
                 if proxyType != PROXY_HTTP {
-                    return nil, fmt.Errorf("only PROXY_HTTP is currently supported")
+                    // handled by transport.DialContext above via the decision
+                    // stashed on req's context; no request-rewriting needed
+                    return nil, nil
                 }
 
                 u_ = "http://" + u_
@@ -212,8 +271,8 @@ func prepareTransport(options map[int]interface{}) (http.RoundTripper, error) {
     } else {
         var proxytype int
         if proxytype_, ok := options[OPT_PROXYTYPE]; ok {
-            if proxytype, ok = proxytype_.(int); !ok || proxytype != PROXY_HTTP {
-                return nil, fmt.Errorf("OPT_PROXYTYPE must be int, and only PROXY_HTTP is currently supported")
+            if proxytype, ok = proxytype_.(int); !ok {
+                return nil, fmt.Errorf("OPT_PROXYTYPE must be int")
             }
         }
 
@@ -222,15 +281,34 @@ func prepareTransport(options map[int]interface{}) (http.RoundTripper, error) {
             if proxy, ok = proxy_.(string); !ok {
                 return nil, fmt.Errorf("OPT_PROXY must be string")
             }
-            proxy = "http://" + proxy
-            proxyUrl, err := url.Parse(proxy)
-            if err != nil {
-                return nil, err
+
+            switch proxytype {
+            case PROXY_HTTP:
+                proxyUrl, err := url.Parse("http://" + proxy)
+                if err != nil {
+                    return nil, err
+                }
+                transport.Proxy = http.ProxyURL(proxyUrl)
+            case PROXY_SOCKS4, PROXY_SOCKS4A, PROXY_SOCKS5:
+                transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+                    return dialSocksProxy(ctx, dialDirect, proxytype, proxy, addr)
+                }
+            default:
+                return nil, fmt.Errorf("OPT_PROXYTYPE must be one of PROXY_HTTP, PROXY_SOCKS4, PROXY_SOCKS4A or PROXY_SOCKS5")
             }
-            transport.Proxy = http.ProxyURL(proxyUrl)
         }
     }
 
+    tlsConfig, err := prepareTLSConfig(options)
+    if err != nil {
+        return nil, err
+    }
+    transport.TLSClientConfig = tlsConfig
+
+    if err := preparePool(transport, options); err != nil {
+        return nil, err
+    }
+
     return transport, nil
 }
 
@@ -393,7 +471,14 @@ func (this *HttpClient) WithCookie(cookie *http.Cookie) *HttpClient {
     return this
 }
 
+// Do is equivalent to DoContext(context.Background(), ...).
 func (this *HttpClient) Do(method string, url string, headers map[string]string, body io.Reader) (*http.Response, error) {
+    return this.DoContext(context.Background(), method, url, headers, body)
+}
+
+// DoContext is like Do, but the request is bound to ctx: the dial, and the
+// whole round trip including retries, are cancelled as soon as ctx is done.
+func (this *HttpClient) DoContext(ctx context.Context, method string, url string, headers map[string]string, body io.Reader) (*http.Response, error) {
     options := mergeOptions(defaultOptions, this.Options, this.oneTimeOptions)
     headers = mergeHeaders(this.oneTimeHeaders, headers)
     cookies := this.oneTimeCookies
@@ -440,52 +525,103 @@ func (this *HttpClient) Do(method string, url string, headers map[string]string,
         return nil, err
     }
 
+    // the timeout is enforced by http.Client.Timeout rather than a conn
+    // deadline, so it doesn't interfere with keep-alives on a reused
+    // transport; it bounds each attempt separately, so with retries the
+    // whole call can take longer than the timeout
+    timeoutMS := 0
+
+    if timeoutMS_, ok := options[OPT_TIMEOUT_MS]; ok {
+        if timeoutMS, ok = timeoutMS_.(int); !ok {
+            return nil, fmt.Errorf("OPT_TIMEOUT_MS must be int")
+        }
+    } else if timeout_, ok := options[OPT_TIMEOUT]; ok {
+        if timeout, ok := timeout_.(int); ok {
+            timeoutMS = timeout * 1000
+        } else {
+            return nil, fmt.Errorf("OPT_TIMEOUT must be int")
+        }
+    }
+
     c := &http.Client {
         Transport: transport,
         CheckRedirect: redirect,
         Jar: jar,
     }
 
+    if timeoutMS > 0 {
+        c.Timeout = time.Duration(timeoutMS) * time.Millisecond
+    }
+
     req, err := prepareRequest(method, url, headers, body, options)
     if err != nil {
         return nil, err
     }
+    req = req.WithContext(ctx)
 
     for _, cookie := range cookies {
         req.AddCookie(cookie)
     }
 
-    return c.Do(req)
+    retry, err := prepareRetry(options)
+    if err != nil {
+        return nil, err
+    }
+
+    resp, err := doWithRetry(c, req, retry)
+    if err != nil {
+        return resp, err
+    }
+
+    // OPT_PROGRESS_FUNC
+    if progressFunc_, ok := options[OPT_PROGRESS_FUNC]; ok {
+        if progressFunc, ok := progressFunc_.(func(int64, int64)); ok {
+            resp.Body = newProgressReader(resp.Body, resp.ContentLength, progressFunc)
+        }
+    }
+
+    return resp, nil
 }
 
 // The GET request
 func (this *HttpClient) Get(url string, params map[string]string) (*http.Response, error) {
+    return this.GetContext(context.Background(), url, params)
+}
+
+// GetContext is like Get, but bound to ctx.
+func (this *HttpClient) GetContext(ctx context.Context, url string, params map[string]string) (*http.Response, error) {
     url = addParams(url, params)
 
-    return this.Do("GET", url, nil, nil)
+    return this.DoContext(ctx, "GET", url, nil, nil)
 }
 
 // The POST request
-// 
-// With multipart set to true, the request will be encoded as "multipart/form-data". 
+//
+// With multipart set to true, the request will be encoded as "multipart/form-data".
 // If any of the params key starts with "@", it is considered as a form file (similar to CURL but different).
 func (this *HttpClient) Post(url string, params map[string]string) (*http.Response, error) {
+    return this.PostContext(context.Background(), url, params)
+}
+
+// PostContext is like Post, but bound to ctx.
+func (this *HttpClient) PostContext(ctx context.Context, url string, params map[string]string) (*http.Response, error) {
     if checkParamFile(params) {
-        return this.PostMultipart(url, params)
+        return this.PostMultipartContext(ctx, url, params)
     }
 
-    headers := this.Headers
-    if headers == nil {
-        headers = make(map[string]string)
-    }
-    headers["Content-Type"] = "application/x-www-form-urlencoded"
+    headers := mergeHeaders(this.Headers, map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
     body := strings.NewReader(paramsToString(params))
 
-    return this.Do("POST", url, headers, body)
+    return this.DoContext(ctx, "POST", url, headers, body)
 }
 
 // Post with the request encoded as "multipart/form-data".
 func (this *HttpClient) PostMultipart(url string, params map[string]string) (*http.Response, error) {
+    return this.PostMultipartContext(context.Background(), url, params)
+}
+
+// PostMultipartContext is like PostMultipart, but bound to ctx.
+func (this *HttpClient) PostMultipartContext(ctx context.Context, url string, params map[string]string) (*http.Response, error) {
     body := &bytes.Buffer{}
     // bodyWriter, _ := body.(io.Writer)
     writer := multipart.NewWriter(body)
@@ -510,7 +646,7 @@ func (this *HttpClient) PostMultipart(url string, params map[string]string) (*ht
         return nil, err
     }
 
-    return this.Do("POST", url, headers, body)
+    return this.DoContext(ctx, "POST", url, headers, body)
 }
 
 func paramsToString(params map[string]string) string {
@@ -606,7 +742,7 @@ func checkParamFile(params map[string]string) bool{
 
 func hasOption(opt int, options []int) bool {
     for _, v := range options {
-        if opt != v {
+        if opt == v {
             return true
         }
     }
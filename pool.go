@@ -0,0 +1,63 @@
+package httpclient
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// preparePool wires OPT_MAX_IDLE_CONNS, OPT_MAX_IDLE_CONNS_PER_HOST,
+// OPT_IDLE_CONN_TIMEOUT_MS, OPT_DISABLE_KEEPALIVES and OPT_DISABLE_COMPRESSION
+// onto transport.
+func preparePool(transport *http.Transport, options map[int]interface{}) error {
+    if maxIdleConns_, ok := options[OPT_MAX_IDLE_CONNS]; ok {
+        maxIdleConns, ok := maxIdleConns_.(int)
+        if !ok {
+            return fmt.Errorf("OPT_MAX_IDLE_CONNS must be int")
+        }
+        transport.MaxIdleConns = maxIdleConns
+    }
+
+    if maxIdleConnsPerHost_, ok := options[OPT_MAX_IDLE_CONNS_PER_HOST]; ok {
+        maxIdleConnsPerHost, ok := maxIdleConnsPerHost_.(int)
+        if !ok {
+            return fmt.Errorf("OPT_MAX_IDLE_CONNS_PER_HOST must be int")
+        }
+        transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+    }
+
+    if idleConnTimeoutMS_, ok := options[OPT_IDLE_CONN_TIMEOUT_MS]; ok {
+        idleConnTimeoutMS, ok := idleConnTimeoutMS_.(int)
+        if !ok {
+            return fmt.Errorf("OPT_IDLE_CONN_TIMEOUT_MS must be int")
+        }
+        transport.IdleConnTimeout = time.Duration(idleConnTimeoutMS) * time.Millisecond
+    }
+
+    if disableKeepalives_, ok := options[OPT_DISABLE_KEEPALIVES]; ok {
+        disableKeepalives, ok := disableKeepalives_.(bool)
+        if !ok {
+            return fmt.Errorf("OPT_DISABLE_KEEPALIVES must be bool")
+        }
+        transport.DisableKeepAlives = disableKeepalives
+    }
+
+    if disableCompression_, ok := options[OPT_DISABLE_COMPRESSION]; ok {
+        disableCompression, ok := disableCompression_.(bool)
+        if !ok {
+            return fmt.Errorf("OPT_DISABLE_COMPRESSION must be bool")
+        }
+        transport.DisableCompression = disableCompression
+    }
+
+    return nil
+}
+
+// CloseIdleConnections closes any connections on this client's transport
+// that are sitting idle in its connection pool. It has no effect unless the
+// transport has actually been built and cached (see reuseTransport).
+func (this *HttpClient) CloseIdleConnections() {
+    if transport, ok := this.transport.(*http.Transport); ok {
+        transport.CloseIdleConnections()
+    }
+}
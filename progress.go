@@ -0,0 +1,59 @@
+package httpclient
+
+import (
+    "fmt"
+    "io"
+    "os"
+)
+
+// progressReader wraps a response body so every Read reports how many bytes
+// have been read so far, and the total expected (-1 if unknown, mirroring
+// http.Response.ContentLength).
+type progressReader struct {
+    io.ReadCloser
+    total    int64
+    read     int64
+    progress func(read, total int64)
+}
+
+func newProgressReader(body io.ReadCloser, total int64, progress func(read, total int64)) io.ReadCloser {
+    return &progressReader{
+        ReadCloser: body,
+        total: total,
+        progress: progress,
+    }
+}
+
+func (this *progressReader) Read(p []byte) (int, error) {
+    n, err := this.ReadCloser.Read(p)
+    if n > 0 {
+        this.read += int64(n)
+        this.progress(this.read, this.total)
+    }
+
+    return n, err
+}
+
+// Download GETs url and streams the response body to path, reporting
+// progress through OPT_PROGRESS_FUNC if it has been set.
+func (this *HttpClient) Download(url string, path string) error {
+    resp, err := this.Get(url, nil)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != 200 {
+        return fmt.Errorf("unexpected status %d downloading %s", resp.StatusCode, url)
+    }
+
+    out, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer out.Close()
+
+    _, err = io.Copy(out, resp.Body)
+
+    return err
+}
@@ -0,0 +1,242 @@
+package httpclient
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "math/rand"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// retryConfig is what OPT_MAX_RETRIES, OPT_RETRY_BACKOFF_MS,
+// OPT_RETRY_MAX_BACKOFF_MS and OPT_RETRY_ON_STATUS parse down to.
+type retryConfig struct {
+    maxRetries int
+    backoff time.Duration
+    maxBackoff time.Duration
+    onStatus map[int]bool
+}
+
+// maxBufferedRetryBody is how much of an otherwise non-replayable request
+// body (i.e. not already a *bytes.Buffer/*bytes.Reader/*strings.Reader,
+// which http.NewRequest already knows how to replay via req.GetBody) we're
+// willing to hold in memory so it can be replayed on retry.
+const maxBufferedRetryBody = 1 << 20 // 1MiB
+
+var defaultRetryOnStatus = func() map[int]bool {
+    onStatus := map[int]bool{429: true}
+    for status := 500; status <= 599; status++ {
+        onStatus[status] = true
+    }
+    return onStatus
+}()
+
+func prepareRetry(options map[int]interface{}) (*retryConfig, error) {
+    retry := &retryConfig {
+        backoff: 100 * time.Millisecond,
+        maxBackoff: 10 * time.Second,
+        onStatus: defaultRetryOnStatus,
+    }
+
+    if maxRetries_, ok := options[OPT_MAX_RETRIES]; ok {
+        maxRetries, ok := maxRetries_.(int)
+        if !ok {
+            return nil, fmt.Errorf("OPT_MAX_RETRIES must be int")
+        }
+        retry.maxRetries = maxRetries
+    }
+
+    if backoffMS_, ok := options[OPT_RETRY_BACKOFF_MS]; ok {
+        backoffMS, ok := backoffMS_.(int)
+        if !ok {
+            return nil, fmt.Errorf("OPT_RETRY_BACKOFF_MS must be int")
+        }
+        retry.backoff = time.Duration(backoffMS) * time.Millisecond
+    }
+
+    if maxBackoffMS_, ok := options[OPT_RETRY_MAX_BACKOFF_MS]; ok {
+        maxBackoffMS, ok := maxBackoffMS_.(int)
+        if !ok {
+            return nil, fmt.Errorf("OPT_RETRY_MAX_BACKOFF_MS must be int")
+        }
+        retry.maxBackoff = time.Duration(maxBackoffMS) * time.Millisecond
+    }
+
+    if onStatus_, ok := options[OPT_RETRY_ON_STATUS]; ok {
+        onStatus, ok := onStatus_.([]int)
+        if !ok {
+            return nil, fmt.Errorf("OPT_RETRY_ON_STATUS must be []int")
+        }
+        statusSet := make(map[int]bool)
+        for _, status := range onStatus {
+            statusSet[status] = true
+        }
+        retry.onStatus = statusSet
+    }
+
+    return retry, nil
+}
+
+// bufferRequestBody makes req replayable by retry, if it isn't already.
+// http.NewRequest only sets req.GetBody for a handful of known io.Reader
+// types (*bytes.Buffer, *bytes.Reader, *strings.Reader); anything else (a
+// file, a custom stream) is drained into memory here, up to
+// maxBufferedRetryBody, so req.GetBody can be wired up to replay it. Bodies
+// larger than that are left alone - req.GetBody stays nil, which simply
+// disables retries for that call - since buffering them would defeat the
+// point of streaming a large body in the first place.
+func bufferRequestBody(req *http.Request) error {
+    if req.Body == nil || req.GetBody != nil {
+        return nil
+    }
+
+    data, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBufferedRetryBody + 1))
+    if err != nil {
+        return err
+    }
+
+    if len(data) > maxBufferedRetryBody {
+        // too big to safely buffer for replay; restore the stream (the
+        // prefix we already consumed, followed by whatever's left of it)
+        req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(data), req.Body))
+        return nil
+    }
+
+    req.Body.Close()
+    req.Body = ioutil.NopCloser(bytes.NewReader(data))
+    req.GetBody = func() (io.ReadCloser, error) {
+        return ioutil.NopCloser(bytes.NewReader(data)), nil
+    }
+
+    return nil
+}
+
+// doWithRetry runs c.Do(req), retrying on retryable transport errors and on
+// retry.onStatus response codes, with exponential backoff (±20% jitter) and
+// Retry-After support. It gives up as soon as the request body can't be
+// safely replayed (req.GetBody is nil on anything but a handful of known
+// io.Reader types, or a body too large to buffer - see bufferRequestBody) or
+// the request's context is cancelled.
+//
+// The number of retries made (0 when the first attempt was final, so the
+// attempt count is one more) is exposed via the X-Httpclient-Retries header
+// on the returned response.
+func doWithRetry(c *http.Client, req *http.Request, retry *retryConfig) (*http.Response, error) {
+    var resp *http.Response
+    var err error
+
+    if retry.maxRetries > 0 {
+        if err := bufferRequestBody(req); err != nil {
+            return nil, err
+        }
+    }
+
+    for attempt := 0; ; attempt++ {
+        attemptReq := req
+
+        if attempt > 0 {
+            attemptReq = req.Clone(req.Context())
+            if req.GetBody != nil {
+                body, gerr := req.GetBody()
+                if gerr != nil {
+                    return resp, gerr
+                }
+                attemptReq.Body = body
+            }
+        }
+
+        resp, err = c.Do(attemptReq)
+
+        if attempt >= retry.maxRetries || !shouldRetry(resp, err, retry.onStatus) {
+            if resp != nil {
+                resp.Header.Set("X-Httpclient-Retries", strconv.Itoa(attempt))
+            }
+            return resp, err
+        }
+
+        // can't safely resend a body we're not able to rebuild
+        if req.Body != nil && req.GetBody == nil {
+            if resp != nil {
+                resp.Header.Set("X-Httpclient-Retries", strconv.Itoa(attempt))
+            }
+            return resp, err
+        }
+
+        delay := retryDelay(resp, retry, attempt)
+
+        if resp != nil {
+            resp.Body.Close()
+        }
+
+        select {
+        case <-req.Context().Done():
+            return nil, req.Context().Err()
+        case <-time.After(delay):
+        }
+    }
+}
+
+func shouldRetry(resp *http.Response, err error, onStatus map[int]bool) bool {
+    if err != nil {
+        return isRetryableError(err)
+    }
+
+    return resp != nil && onStatus[resp.StatusCode]
+}
+
+func isRetryableError(err error) bool {
+    if err == nil {
+        return false
+    }
+
+    if urlErr, ok := err.(*url.Error); ok {
+        return isRetryableError(urlErr.Err)
+    }
+
+    if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+        return true
+    }
+
+    return strings.Contains(err.Error(), "connection reset")
+}
+
+// retryDelay honors a Retry-After header on 429/503 responses, falling back
+// to backoff * 2^attempt capped at maxBackoff, jittered by up to ±20%.
+func retryDelay(resp *http.Response, retry *retryConfig, attempt int) time.Duration {
+    if resp != nil && (resp.StatusCode == 429 || resp.StatusCode == 503) {
+        if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+            return d
+        }
+    }
+
+    backoff := retry.backoff << uint(attempt)
+    if backoff <= 0 || backoff > retry.maxBackoff {
+        backoff = retry.maxBackoff
+    }
+
+    jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(backoff))
+
+    return backoff + jitter
+}
+
+func parseRetryAfter(header string) (time.Duration, bool) {
+    if header == "" {
+        return 0, false
+    }
+
+    if seconds, err := strconv.Atoi(header); err == nil {
+        return time.Duration(seconds) * time.Second, true
+    }
+
+    if when, err := http.ParseTime(header); err == nil {
+        return time.Until(when), true
+    }
+
+    return 0, false
+}
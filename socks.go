@@ -0,0 +1,241 @@
+package httpclient
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "strconv"
+)
+
+// Minimal SOCKS4/SOCKS4a/SOCKS5 CONNECT handshakes, used by prepareTransport
+// when OPT_PROXYTYPE selects one of the PROXY_SOCKS* constants. Implemented
+// directly against the wire protocols rather than pulling in
+// golang.org/x/net/proxy, so the package stays dependency-free.
+
+const socks4UserId = "go-httpclient"
+
+// proxyFuncDecisionKey is the context key transport.Proxy uses to hand its
+// OPT_PROXY_FUNC result to transport.DialContext for the same request; see
+// prepareTransport's OPT_PROXY_FUNC branch.
+type proxyFuncDecisionKey struct{}
+
+type proxyFuncDecision struct {
+    proxyType int
+    proxyAddr string
+}
+
+// dialSocksProxy dials proxyAddr with dial, then asks it to relay a TCP
+// connection through to targetAddr using the given SOCKS variant. proxyAddr
+// may carry "user:pass@" userinfo, which is used for SOCKS5 auth (SOCKS4/4a
+// have no equivalent and ignore it).
+func dialSocksProxy(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), proxyType int, proxyAddr, targetAddr string) (net.Conn, error) {
+    user, pass, hostport, err := splitProxyAuth(proxyAddr)
+    if err != nil {
+        return nil, err
+    }
+
+    conn, err := dial(ctx, "tcp", hostport)
+    if err != nil {
+        return nil, err
+    }
+
+    switch proxyType {
+    case PROXY_SOCKS4:
+        err = socks4Handshake(conn, targetAddr, false)
+    case PROXY_SOCKS4A:
+        err = socks4Handshake(conn, targetAddr, true)
+    case PROXY_SOCKS5:
+        err = socks5Handshake(conn, targetAddr, user, pass)
+    default:
+        err = fmt.Errorf("unsupported SOCKS proxy type %d", proxyType)
+    }
+
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    return conn, nil
+}
+
+// splitProxyAuth pulls an optional "user:pass@" prefix off proxyAddr.
+func splitProxyAuth(proxyAddr string) (user, pass, hostport string, err error) {
+    u, err := url.Parse("socks://" + proxyAddr)
+    if err != nil {
+        return "", "", "", fmt.Errorf("invalid SOCKS proxy address %q: %v", proxyAddr, err)
+    }
+
+    if u.User != nil {
+        user = u.User.Username()
+        pass, _ = u.User.Password()
+    }
+
+    return user, pass, u.Host, nil
+}
+
+func socks4Handshake(conn net.Conn, targetAddr string, socks4a bool) error {
+    host, portStr, err := net.SplitHostPort(targetAddr)
+    if err != nil {
+        return err
+    }
+
+    port, err := strconv.Atoi(portStr)
+    if err != nil {
+        return fmt.Errorf("invalid port %q: %v", portStr, err)
+    }
+
+    req := []byte{0x04, 0x01, byte(port >> 8), byte(port)}
+
+    var hostname string
+    if ip := net.ParseIP(host); ip != nil {
+        ip4 := ip.To4()
+        if ip4 == nil {
+            return fmt.Errorf("SOCKS4 does not support IPv6 addresses")
+        }
+        req = append(req, ip4...)
+    } else {
+        if !socks4a {
+            return fmt.Errorf("SOCKS4 requires an IP address, got %q (use PROXY_SOCKS4A for hostnames)", host)
+        }
+        // SOCKS4a: signal a hostname-based request with an invalid IP in the
+        // 0.0.0.x range, then append the hostname after the user ID.
+        req = append(req, 0, 0, 0, 1)
+        hostname = host
+    }
+
+    req = append(req, []byte(socks4UserId)...)
+    req = append(req, 0)
+
+    if hostname != "" {
+        req = append(req, []byte(hostname)...)
+        req = append(req, 0)
+    }
+
+    if _, err := conn.Write(req); err != nil {
+        return err
+    }
+
+    resp := make([]byte, 8)
+    if _, err := io.ReadFull(conn, resp); err != nil {
+        return err
+    }
+
+    if resp[1] != 0x5a {
+        return fmt.Errorf("SOCKS4 proxy refused connection, code %#x", resp[1])
+    }
+
+    return nil
+}
+
+func socks5Handshake(conn net.Conn, targetAddr, user, pass string) error {
+    methods := []byte{0x00}
+    if user != "" {
+        methods = []byte{0x02, 0x00}
+    }
+
+    if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+        return err
+    }
+
+    greetResp := make([]byte, 2)
+    if _, err := io.ReadFull(conn, greetResp); err != nil {
+        return err
+    }
+    if greetResp[0] != 0x05 {
+        return fmt.Errorf("not a SOCKS5 proxy")
+    }
+
+    switch greetResp[1] {
+    case 0x00:
+        // no authentication required
+    case 0x02:
+        if err := socks5Authenticate(conn, user, pass); err != nil {
+            return err
+        }
+    default:
+        return fmt.Errorf("SOCKS5 proxy rejected all authentication methods")
+    }
+
+    host, portStr, err := net.SplitHostPort(targetAddr)
+    if err != nil {
+        return err
+    }
+
+    port, err := strconv.Atoi(portStr)
+    if err != nil {
+        return fmt.Errorf("invalid port %q: %v", portStr, err)
+    }
+
+    req := []byte{0x05, 0x01, 0x00}
+
+    if ip := net.ParseIP(host); ip != nil {
+        if ip4 := ip.To4(); ip4 != nil {
+            req = append(req, 0x01)
+            req = append(req, ip4...)
+        } else {
+            req = append(req, 0x04)
+            req = append(req, ip.To16()...)
+        }
+    } else {
+        if len(host) > 255 {
+            return fmt.Errorf("SOCKS5 hostname too long: %q", host)
+        }
+        req = append(req, 0x03, byte(len(host)))
+        req = append(req, []byte(host)...)
+    }
+
+    req = append(req, byte(port >> 8), byte(port))
+
+    if _, err := conn.Write(req); err != nil {
+        return err
+    }
+
+    header := make([]byte, 4)
+    if _, err := io.ReadFull(conn, header); err != nil {
+        return err
+    }
+    if header[1] != 0x00 {
+        return fmt.Errorf("SOCKS5 proxy refused connection, code %#x", header[1])
+    }
+
+    // discard the bound address the proxy reports back; its length depends
+    // on the address type in header[3]
+    switch header[3] {
+    case 0x01:
+        _, err = io.ReadFull(conn, make([]byte, 4 + 2))
+    case 0x03:
+        lenBuf := make([]byte, 1)
+        if _, err = io.ReadFull(conn, lenBuf); err == nil {
+            _, err = io.ReadFull(conn, make([]byte, int(lenBuf[0]) + 2))
+        }
+    case 0x04:
+        _, err = io.ReadFull(conn, make([]byte, 16 + 2))
+    default:
+        return fmt.Errorf("SOCKS5 proxy returned unknown address type %#x", header[3])
+    }
+
+    return err
+}
+
+func socks5Authenticate(conn net.Conn, user, pass string) error {
+    req := []byte{0x01, byte(len(user))}
+    req = append(req, []byte(user)...)
+    req = append(req, byte(len(pass)))
+    req = append(req, []byte(pass)...)
+
+    if _, err := conn.Write(req); err != nil {
+        return err
+    }
+
+    resp := make([]byte, 2)
+    if _, err := io.ReadFull(conn, resp); err != nil {
+        return err
+    }
+    if resp[1] != 0x00 {
+        return fmt.Errorf("SOCKS5 authentication failed")
+    }
+
+    return nil
+}
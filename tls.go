@@ -0,0 +1,97 @@
+package httpclient
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "io/ioutil"
+)
+
+// ClientCertFiles identifies a client certificate/key pair on disk, for use
+// with OPT_CLIENT_CERT as an alternative to passing an already-loaded
+// tls.Certificate.
+type ClientCertFiles struct {
+    CertFile string
+    KeyFile string
+}
+
+// prepareTLSConfig builds the *tls.Config (if any) that should be attached
+// to transport.TLSClientConfig, from OPT_INSECURE, OPT_TLS_CONFIG,
+// OPT_CLIENT_CERT and OPT_ROOT_CAS.
+func prepareTLSConfig(options map[int]interface{}) (*tls.Config, error) {
+    var config *tls.Config
+
+    // OPT_TLS_CONFIG, used as the starting point so the other options can
+    // layer on top of it
+    if tlsConfig_, ok := options[OPT_TLS_CONFIG]; ok {
+        tlsConfig, ok := tlsConfig_.(*tls.Config)
+        if !ok {
+            return nil, fmt.Errorf("OPT_TLS_CONFIG must be *tls.Config")
+        }
+        config = tlsConfig.Clone()
+    }
+
+    // OPT_INSECURE
+    if insecure_, ok := options[OPT_INSECURE]; ok {
+        insecure, ok := insecure_.(bool)
+        if !ok {
+            return nil, fmt.Errorf("OPT_INSECURE must be bool")
+        }
+        if config == nil {
+            config = &tls.Config{}
+        }
+        config.InsecureSkipVerify = insecure
+    }
+
+    // OPT_CLIENT_CERT
+    if cert_, ok := options[OPT_CLIENT_CERT]; ok {
+        var cert tls.Certificate
+        var err error
+
+        switch v := cert_.(type) {
+        case tls.Certificate:
+            cert = v
+        case ClientCertFiles:
+            cert, err = tls.LoadX509KeyPair(v.CertFile, v.KeyFile)
+        default:
+            return nil, fmt.Errorf("OPT_CLIENT_CERT must be tls.Certificate or ClientCertFiles")
+        }
+
+        if err != nil {
+            return nil, err
+        }
+
+        if config == nil {
+            config = &tls.Config{}
+        }
+        config.Certificates = append(config.Certificates, cert)
+    }
+
+    // OPT_ROOT_CAS
+    if rootCAs_, ok := options[OPT_ROOT_CAS]; ok {
+        var pool *x509.CertPool
+
+        switch v := rootCAs_.(type) {
+        case *x509.CertPool:
+            pool = v
+        case string:
+            pem, err := ioutil.ReadFile(v)
+            if err != nil {
+                return nil, err
+            }
+            pool = x509.NewCertPool()
+            if !pool.AppendCertsFromPEM(pem) {
+                return nil, fmt.Errorf("no certificates found in %q", v)
+            }
+        default:
+            return nil, fmt.Errorf("OPT_ROOT_CAS must be *x509.CertPool or a PEM bundle path")
+        }
+
+        if config == nil {
+            config = &tls.Config{}
+        }
+        config.RootCAs = pool
+    }
+
+    return config, nil
+}